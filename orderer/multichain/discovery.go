@@ -0,0 +1,355 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/config"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/utils"
+)
+
+const (
+	// defaultPullInterval is how often an anti-entropy round runs when the
+	// caller does not override it via WithChannelDiscovery.
+	defaultPullInterval = 10 * time.Second
+
+	// defaultMaxConcurrentPulls bounds how many chains may be pulled at
+	// once, so that a node with many missing chains does not overwhelm a
+	// single peer.
+	defaultMaxConcurrentPulls = 2
+)
+
+// ChannelDiscovery lets a freshly joined orderer discover channels that
+// already exist elsewhere on the network and pull their full block range,
+// so the local orderer can materialize them without an operator manually
+// supplying each channel's genesis block. Discovery never touches
+// the system channel: that still has to be available locally (or supplied
+// via the usual bootstrap file) before NewManagerImpl can construct a
+// Manager at all.
+type ChannelDiscovery interface {
+	// Run starts periodic anti-entropy pull rounds against peers returned
+	// by the configured DiscoveryTransport, until stopCh is closed.
+	Run(stopCh <-chan struct{})
+}
+
+// DiscoveryTransport abstracts the RPC used to exchange channel metadata
+// with, and pull blocks from, other orderers. grpcTransport is the initial
+// implementation; it is defined behind this interface so alternate
+// transports (or test doubles) can be substituted.
+type DiscoveryTransport interface {
+	// Peers returns the orderer addresses currently eligible to pull from.
+	Peers() []string
+
+	// SetPeers replaces the set of peer addresses future pull rounds
+	// select from.
+	SetPeers(peers []string)
+
+	// KnownChains asks peer which chainIDs it knows about, and the hash of
+	// each chain's latest config block.
+	KnownChains(peer string) (map[string][]byte, error)
+
+	// PullChain streams every block of chainID from peer, from its genesis
+	// block through its latest config block inclusive, in order, so the
+	// caller can replay them onto a freshly created local ledger and end up
+	// with the same block numbers and hash chaining as the source chain.
+	PullChain(peer string, chainID string) (blocks []*cb.Block, err error)
+}
+
+// WithChannelDiscovery enables the gossip-style channel discovery
+// subsystem, pulling chains a newly joined orderer is missing from the
+// given transport's peers. pullInterval and maxConcurrentPulls may be
+// zero to accept their defaults.
+func WithChannelDiscovery(transport DiscoveryTransport, pullInterval time.Duration, maxConcurrentPulls int) ManagerOption {
+	return func(ml *multiLedger) {
+		ml.channelDiscovery = newGossipChannelDiscovery(ml, transport, pullInterval, maxConcurrentPulls)
+	}
+}
+
+// gossipChannelDiscovery implements ChannelDiscovery with a gossip-style
+// anti-entropy protocol: each round it asks one randomly chosen peer for
+// its known chainIDs, and pulls any the local orderer does not yet have.
+type gossipChannelDiscovery struct {
+	ml                 *multiLedger
+	transport          DiscoveryTransport
+	pullInterval       time.Duration
+	maxConcurrentPulls int
+	inflight           chan struct{}
+
+	// pullingMutex guards pulling, the set of chainIDs with a pull
+	// currently in flight. Without it, two pull rounds racing on the same
+	// missing chainID could both pass the GetChain check in
+	// adoptDiscoveredChain and double-Append its genesis block.
+	pullingMutex sync.Mutex
+	pulling      map[string]struct{}
+}
+
+func newGossipChannelDiscovery(ml *multiLedger, transport DiscoveryTransport, pullInterval time.Duration, maxConcurrentPulls int) *gossipChannelDiscovery {
+	if pullInterval <= 0 {
+		pullInterval = defaultPullInterval
+	}
+	if maxConcurrentPulls <= 0 {
+		maxConcurrentPulls = defaultMaxConcurrentPulls
+	}
+
+	return &gossipChannelDiscovery{
+		ml:                 ml,
+		transport:          transport,
+		pullInterval:       pullInterval,
+		maxConcurrentPulls: maxConcurrentPulls,
+		inflight:           make(chan struct{}, maxConcurrentPulls),
+		pulling:            make(map[string]struct{}),
+	}
+}
+
+// Run blocks, running one anti-entropy round every pullInterval, until
+// stopCh is closed.
+func (gcd *gossipChannelDiscovery) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(gcd.pullInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			gcd.pullRound()
+		}
+	}
+}
+
+// pullRound contacts a single randomly selected peer, and kicks off a pull
+// for each chain the peer knows about that the local orderer does not.
+// Pulls run in the background so one slow peer cannot stall the round; the
+// inflight semaphore provides backpressure by deferring any pulls beyond
+// maxConcurrentPulls to a later round instead of queuing unbounded work.
+func (gcd *gossipChannelDiscovery) pullRound() {
+	peers := gcd.transport.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[rand.Intn(len(peers))]
+
+	remoteChains, err := gcd.transport.KnownChains(peer)
+	if err != nil {
+		logger.Warningf("channel discovery: failed contacting peer %s: %s", peer, err)
+		return
+	}
+
+	for chainID, configBlockHash := range remoteChains {
+		if _, ok := gcd.ml.GetChain(chainID); ok {
+			continue
+		}
+
+		if !gcd.beginPull(chainID) {
+			continue
+		}
+
+		select {
+		case gcd.inflight <- struct{}{}:
+		default:
+			logger.Debugf("channel discovery: deferring pull of %s, already %d pulls in flight", chainID, gcd.maxConcurrentPulls)
+			gcd.endPull(chainID)
+			continue
+		}
+
+		go func(peer, chainID string, configBlockHash []byte) {
+			defer func() { <-gcd.inflight; gcd.endPull(chainID) }()
+			gcd.pullAndAdopt(peer, chainID, configBlockHash)
+		}(peer, chainID, configBlockHash)
+	}
+}
+
+// beginPull claims chainID for this goroutine's pull attempt, returning
+// false if another pull for the same chainID is already in flight.
+func (gcd *gossipChannelDiscovery) beginPull(chainID string) bool {
+	gcd.pullingMutex.Lock()
+	defer gcd.pullingMutex.Unlock()
+
+	if _, ok := gcd.pulling[chainID]; ok {
+		return false
+	}
+	gcd.pulling[chainID] = struct{}{}
+	return true
+}
+
+func (gcd *gossipChannelDiscovery) endPull(chainID string) {
+	gcd.pullingMutex.Lock()
+	defer gcd.pullingMutex.Unlock()
+	delete(gcd.pulling, chainID)
+}
+
+func (gcd *gossipChannelDiscovery) pullAndAdopt(peer, chainID string, advertisedConfigBlockHash []byte) {
+	blocks, err := gcd.transport.PullChain(peer, chainID)
+	if err != nil {
+		logger.Warningf("channel discovery: failed pulling chain %s from %s: %s", chainID, peer, err)
+		return
+	}
+
+	if len(blocks) == 0 {
+		logger.Warningf("channel discovery: rejecting chain %s pulled from %s: transport returned no blocks", chainID, peer)
+		return
+	}
+
+	configBlock := blocks[len(blocks)-1]
+	if err := verifyConfigBlockHash(configBlock, advertisedConfigBlockHash); err != nil {
+		logger.Warningf("channel discovery: rejecting chain %s pulled from %s: %s", chainID, peer, err)
+		return
+	}
+
+	if err := gcd.ml.adoptDiscoveredChain(blocks); err != nil {
+		logger.Warningf("channel discovery: rejecting chain %s pulled from %s: %s", chainID, peer, err)
+	}
+}
+
+// verifyConfigBlockHash checks that configBlock's header hashes to
+// advertisedConfigBlockHash, the value KnownChains reported for this chain
+// before it was pulled. This is the only defense pullAndAdopt has against a
+// peer that serves a different chain (or a stale or rolled-back one) than
+// the one it advertised; verifyBlockChain takes over from here to confirm
+// the rest of the range hash-chains back to it.
+func verifyConfigBlockHash(configBlock *cb.Block, advertisedConfigBlockHash []byte) error {
+	actualHash := utils.GetBlockHeaderHash(configBlock.Header)
+	if !bytes.Equal(actualHash, advertisedConfigBlockHash) {
+		return fmt.Errorf("config block hash %x did not match the hash %x advertised by KnownChains", actualHash, advertisedConfigBlockHash)
+	}
+	return nil
+}
+
+// adoptDiscoveredChain takes the full genesis-through-latest-config block
+// range pullAndAdopt has already checked against the advertised config
+// block hash, verifies that the whole range hash-chains together and
+// that it references a consortium the system channel actually knows
+// about, and, if it checks out, replays every block onto a freshly
+// created local ledger and starts the chain exactly as newChain does for
+// a locally originated channel creation. Replaying the full range,
+// rather than just the genesis and config blocks, keeps the local
+// ledger's block numbers and hash chaining identical to the source
+// chain's: getConfigTx later looks up a chain's latest config block by
+// the absolute index recorded in its last block's metadata, which only
+// resolves correctly if every block in between is also present locally.
+func (ml *multiLedger) adoptDiscoveredChain(blocks []*cb.Block) error {
+	if err := verifyBlockChain(blocks); err != nil {
+		return fmt.Errorf("pulled block range for chain does not form a valid chain: %s", err)
+	}
+
+	configBlock := blocks[len(blocks)-1]
+
+	configEnv := &cb.ConfigEnvelope{}
+	if err := utils.UnmarshalEnvelopeOfType(utils.ExtractEnvelopeOrPanic(configBlock, 0), cb.HeaderType_CONFIG, configEnv); err != nil {
+		return fmt.Errorf("failed unmarshaling pulled config block: %s", err)
+	}
+
+	channelGroup := configEnv.Config.ChannelGroup
+	consortiumConfigValue, ok := channelGroup.Values[config.ConsortiumKey]
+	if !ok {
+		return fmt.Errorf("pulled config block has no consortium value")
+	}
+
+	consortium := &cb.Consortium{}
+	if err := proto.Unmarshal(consortiumConfigValue.Value, consortium); err != nil {
+		return fmt.Errorf("failed unmarshaling consortium name: %s", err)
+	}
+
+	systemChannelGroup := ml.systemChannel.ConfigEnvelope().Config.ChannelGroup
+	if err := verifyConsortiumMembership(systemChannelGroup, consortium.Name); err != nil {
+		return err
+	}
+
+	configTx := utils.ExtractEnvelopeOrPanic(configBlock, 0)
+	ledgerResources := ml.newLedgerResources(configTx)
+	chainID := ledgerResources.ChainID()
+
+	if _, ok := ml.GetChain(chainID); ok {
+		// Another pull round (or a local creation) beat us to it.
+		return nil
+	}
+
+	for _, block := range blocks {
+		ledgerResources.ledger.Append(block)
+	}
+
+	cs := newChainSupport(createStandardFilters(ledgerResources), ledgerResources, ml.consenters, ml.signer)
+	logger.Infof("Discovered and starting chain %s", chainID)
+	ml.dispatchChannelEvent(ChannelCreated, chainID, cs)
+
+	// Start the chain before publishing it via addChain, exactly as newChain
+	// does: GetChain is read lock-free by broadcast/deliver goroutines with
+	// no synchronization against cs.start(), so a racing reader could
+	// resolve a chainSupport whose consenter has not started yet otherwise.
+	cs.start()
+	ml.addChain(chainID, cs)
+	ml.dispatchChannelEvent(ChannelStarted, chainID, cs)
+
+	return nil
+}
+
+// verifyBlockChain checks that blocks forms a single, contiguous,
+// properly hash-chained range starting at the chain's genesis block:
+// blocks[0] has Header.Number == 0, every subsequent block's Header.Number
+// is exactly one more than the block before it, and its Header.PreviousHash
+// equals the hash of the prior block's header. pullAndAdopt only checks
+// the final block's hash against what KnownChains advertised; without this,
+// a peer could splice in a fabricated genesis block or arbitrary interior
+// blocks as long as the final block it serves still hashes to the value it
+// advertised itself.
+func verifyBlockChain(blocks []*cb.Block) error {
+	if blocks[0].Header.Number != 0 {
+		return fmt.Errorf("first block has number %d, expected the genesis block's number 0", blocks[0].Header.Number)
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		prev, block := blocks[i-1], blocks[i]
+
+		if block.Header.Number != prev.Header.Number+1 {
+			return fmt.Errorf("block number %d does not immediately follow block number %d", block.Header.Number, prev.Header.Number)
+		}
+
+		if prevHash := utils.GetBlockHeaderHash(prev.Header); !bytes.Equal(block.Header.PreviousHash, prevHash) {
+			return fmt.Errorf("block %d's PreviousHash %x does not match the hash %x of block %d", block.Header.Number, block.Header.PreviousHash, prevHash, prev.Header.Number)
+		}
+	}
+
+	return nil
+}
+
+// verifyConsortiumMembership checks that consortiumName names a consortium
+// systemChannelGroup actually knows about. It operates on the raw
+// *cb.ConfigGroup rather than the configtxapi.Manager-backed
+// ConsortiumsConfig() the rest of this package normally uses for the same
+// lookup, mirroring applyApplicationGroupMembership's approach in
+// manager.go, so that a chain pulled from a peer gets membership scrutiny
+// at least as strict as a channel created locally, not merely whatever a
+// peer happened to advertise.
+func verifyConsortiumMembership(systemChannelGroup *cb.ConfigGroup, consortiumName string) error {
+	consortiumsGroup, ok := systemChannelGroup.Groups[config.ConsortiumsGroupKey]
+	if !ok {
+		return fmt.Errorf("local system channel does not support consortiums")
+	}
+
+	if _, ok := consortiumsGroup.Groups[consortiumName]; !ok {
+		return fmt.Errorf("pulled chain references unknown consortium %s", consortiumName)
+	}
+
+	return nil
+}
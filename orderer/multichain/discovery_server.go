@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/orderer/ledger"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"golang.org/x/net/context"
+)
+
+// NewChannelDiscoveryServer returns an ab.ChannelDiscoveryServer that
+// answers KnownChains and PullChain out of ml's own chains, so it can be
+// registered against the orderer's grpc.Server with
+// ab.RegisterChannelDiscoveryServer to let other orderers bootstrap from
+// this one. It never contacts a peer itself; it only serves what this
+// orderer already has locally.
+func NewChannelDiscoveryServer(ml *multiLedger) ab.ChannelDiscoveryServer {
+	return &channelDiscoveryServer{ml: ml}
+}
+
+type channelDiscoveryServer struct {
+	ml *multiLedger
+}
+
+// KnownChains returns every chain this orderer currently has, together
+// with the hash of each one's latest config block, computed the same way
+// a pulling orderer verifies it in gossipChannelDiscovery.pullAndAdopt.
+func (s *channelDiscoveryServer) KnownChains(ctx context.Context, req *ab.KnownChainsRequest) (*ab.KnownChainsResponse, error) {
+	resp := &ab.KnownChainsResponse{}
+	for _, chainID := range s.ml.chainIDs() {
+		configBlock, err := s.ml.latestConfigBlock(chainID)
+		if err != nil {
+			logger.Warningf("channel discovery: omitting chain %s from KnownChains response: %s", chainID, err)
+			continue
+		}
+		resp.Chains = append(resp.Chains, &ab.ChainSummary{
+			ChainId:         chainID,
+			ConfigBlockHash: utils.GetBlockHeaderHash(configBlock.Header),
+		})
+	}
+	return resp, nil
+}
+
+// PullChain streams chainID's full block range, from its genesis block
+// through its latest config block inclusive, to the caller. adoptDiscoveredChain
+// on the receiving end relies on getting every block in between, not just
+// the two endpoints, to keep its local ledger's block numbers and
+// last-config-index consistent with this chain's.
+func (s *channelDiscoveryServer) PullChain(req *ab.PullChainRequest, stream ab.ChannelDiscovery_PullChainServer) error {
+	reader, ok := s.ml.chainReader(req.ChainId)
+	if !ok {
+		return fmt.Errorf("unknown chain %s", req.ChainId)
+	}
+
+	configBlock, err := s.ml.latestConfigBlock(req.ChainId)
+	if err != nil {
+		return fmt.Errorf("failed locating latest config block for chain %s: %s", req.ChainId, err)
+	}
+
+	for number := uint64(0); number <= configBlock.Header.Number; number++ {
+		block := ledger.GetBlock(reader, number)
+		if block == nil {
+			return fmt.Errorf("chain %s is missing block %d", req.ChainId, number)
+		}
+		if err := stream.Send(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
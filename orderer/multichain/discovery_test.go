@@ -0,0 +1,412 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/config"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/utils"
+)
+
+// fakeTransport is a DiscoveryTransport test double. KnownChains and
+// PullChain responses are fixed at construction; PullChain optionally
+// blocks on release until the test closes it, so tests can observe
+// gossipChannelDiscovery's state while a pull is in flight.
+type fakeTransport struct {
+	mutex sync.Mutex
+	peers []string
+
+	knownChains map[string]map[string][]byte
+	release     chan struct{}
+
+	// blocks and pullErr, if either is set, are returned by PullChain
+	// verbatim instead of its historical always-error response, so tests
+	// that need pullAndAdopt to actually see a block range can supply one.
+	blocks  []*cb.Block
+	pullErr error
+
+	pullCalls int
+}
+
+func (ft *fakeTransport) Peers() []string {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return append([]string(nil), ft.peers...)
+}
+
+func (ft *fakeTransport) SetPeers(peers []string) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.peers = append([]string(nil), peers...)
+}
+
+func (ft *fakeTransport) KnownChains(peer string) (map[string][]byte, error) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return ft.knownChains[peer], nil
+}
+
+// PullChain blocks until ft.release is closed (if set), then returns
+// ft.blocks/ft.pullErr. Tests that only care about gossipChannelDiscovery's
+// pull bookkeeping can leave both unset, in which case PullChain falls back
+// to always returning an error, so they never have to also construct a
+// valid genesis/config block pair.
+func (ft *fakeTransport) PullChain(peer string, chainID string) ([]*cb.Block, error) {
+	ft.mutex.Lock()
+	ft.pullCalls++
+	release := ft.release
+	blocks, pullErr := ft.blocks, ft.pullErr
+	ft.mutex.Unlock()
+
+	if release != nil {
+		<-release
+	}
+
+	if blocks == nil && pullErr == nil {
+		return nil, fmt.Errorf("fakeTransport: pull of %s declined", chainID)
+	}
+	return blocks, pullErr
+}
+
+func (ft *fakeTransport) callCount() int {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return ft.pullCalls
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %s", timeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBeginPullEndPullDedup(t *testing.T) {
+	gcd := newGossipChannelDiscovery(newTestMultiLedger(), &fakeTransport{}, 0, 0)
+
+	if !gcd.beginPull("mychannel") {
+		t.Fatalf("expected the first beginPull to succeed")
+	}
+	if gcd.beginPull("mychannel") {
+		t.Fatalf("expected a second beginPull for the same chain to be rejected while the first is in flight")
+	}
+
+	gcd.endPull("mychannel")
+
+	if !gcd.beginPull("mychannel") {
+		t.Fatalf("expected beginPull to succeed again once the prior pull ended")
+	}
+}
+
+// TestBeginPullDedupUnderConcurrency races many goroutines to beginPull the
+// same chainID, the same contention pullRound and a concurrent pullRound
+// triggered by another anti-entropy tick would create. Run with -race.
+func TestBeginPullDedupUnderConcurrency(t *testing.T) {
+	gcd := newGossipChannelDiscovery(newTestMultiLedger(), &fakeTransport{}, 0, 0)
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	var successes int32
+	var mutex sync.Mutex
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if gcd.beginPull("mychannel") {
+				mutex.Lock()
+				successes++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Fatalf("expected exactly one of %d concurrent beginPull calls to succeed, got %d", attempts, successes)
+	}
+}
+
+// TestPullRoundSkipsChainAlreadyPresent ensures a chain the local orderer
+// already has is never pulled, even when the peer advertises it.
+func TestPullRoundSkipsChainAlreadyPresent(t *testing.T) {
+	ml := newTestMultiLedger()
+	ml.addChain("existing", &chainSupport{})
+
+	transport := &fakeTransport{
+		peers: []string{"peer1"},
+		knownChains: map[string]map[string][]byte{
+			"peer1": {"existing": []byte("hash")},
+		},
+	}
+
+	gcd := newGossipChannelDiscovery(ml, transport, 0, 0)
+	gcd.pullRound()
+
+	waitFor(t, time.Second, func() bool { return len(gcd.inflight) == 0 })
+
+	if calls := transport.callCount(); calls != 0 {
+		t.Fatalf("expected PullChain not to be called for a chain already present, got %d calls", calls)
+	}
+}
+
+// TestPullRoundPullsMissingChain exercises the happy path: a chain the
+// peer advertises that the local orderer does not have gets pulled.
+func TestPullRoundPullsMissingChain(t *testing.T) {
+	ml := newTestMultiLedger()
+	transport := &fakeTransport{
+		peers: []string{"peer1"},
+		knownChains: map[string]map[string][]byte{
+			"peer1": {"missing": []byte("hash")},
+		},
+	}
+
+	gcd := newGossipChannelDiscovery(ml, transport, 0, 0)
+	gcd.pullRound()
+
+	waitFor(t, time.Second, func() bool { return transport.callCount() == 1 })
+
+	waitFor(t, time.Second, func() bool {
+		gcd.pullingMutex.Lock()
+		defer gcd.pullingMutex.Unlock()
+		_, pulling := gcd.pulling["missing"]
+		return !pulling
+	})
+}
+
+// TestPullRoundBackpressureDefersExcessPulls ensures that once
+// maxConcurrentPulls pulls are in flight, pullRound defers any further
+// pulls to a later round instead of queuing unbounded background work.
+func TestPullRoundBackpressureDefersExcessPulls(t *testing.T) {
+	ml := newTestMultiLedger()
+	release := make(chan struct{})
+	transport := &fakeTransport{
+		peers: []string{"peer1"},
+		knownChains: map[string]map[string][]byte{
+			"peer1": {
+				"chain-a": []byte("hash-a"),
+				"chain-b": []byte("hash-b"),
+			},
+		},
+		release: release,
+	}
+
+	gcd := newGossipChannelDiscovery(ml, transport, 0, 1)
+	gcd.pullRound()
+
+	// Exactly one of the two missing chains should have claimed the single
+	// inflight slot and be blocked inside PullChain; the other must have
+	// been deferred (beginPull'd, then immediately endPull'd) rather than
+	// left queued behind it.
+	waitFor(t, time.Second, func() bool { return transport.callCount() == 1 })
+
+	gcd.pullingMutex.Lock()
+	pullingCount := len(gcd.pulling)
+	gcd.pullingMutex.Unlock()
+	if pullingCount != 1 {
+		t.Fatalf("expected exactly 1 chain still marked pulling while the other was deferred, got %d", pullingCount)
+	}
+
+	close(release)
+	waitFor(t, time.Second, func() bool { return len(gcd.inflight) == 0 })
+}
+
+// newTestConfigEnvelope builds a *cb.Envelope wrapping a CONFIG payload
+// around channelGroup, the way a real config block's first transaction
+// would, without requiring a signer: UnmarshalEnvelopeOfType only looks at
+// the channel header's type and the payload data, so this is enough for
+// adoptDiscoveredChain and its helpers to parse.
+func newTestConfigEnvelope(t *testing.T, channelID string, channelGroup *cb.ConfigGroup) *cb.Envelope {
+	t.Helper()
+
+	configEnvBytes, err := proto.Marshal(&cb.ConfigEnvelope{Config: &cb.Config{ChannelGroup: channelGroup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelHeaderBytes, err := proto.Marshal(&cb.ChannelHeader{Type: int32(cb.HeaderType_CONFIG), ChannelId: channelID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloadBytes, err := proto.Marshal(&cb.Payload{
+		Header: &cb.Header{ChannelHeader: channelHeaderBytes},
+		Data:   configEnvBytes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &cb.Envelope{Payload: payloadBytes}
+}
+
+// newTestBlock builds a *cb.Block at number, chained onto previous (nil for
+// the genesis block), carrying envelope as its sole transaction.
+func newTestBlock(t *testing.T, number uint64, previous *cb.Block, envelope *cb.Envelope) *cb.Block {
+	t.Helper()
+
+	envelopeBytes, err := proto.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := &cb.BlockHeader{Number: number}
+	if previous != nil {
+		header.PreviousHash = utils.GetBlockHeaderHash(previous.Header)
+	}
+
+	return &cb.Block{
+		Header: header,
+		Data:   &cb.BlockData{Data: [][]byte{envelopeBytes}},
+	}
+}
+
+// newTestChainBlocks builds a minimal, properly hash-chained genesis+config
+// block pair for chainID, referencing consortiumName, the smallest range
+// verifyBlockChain and adoptDiscoveredChain will accept.
+func newTestChainBlocks(t *testing.T, chainID, consortiumName string) []*cb.Block {
+	t.Helper()
+
+	channelGroup := cb.NewConfigGroup()
+	consortiumBytes, err := proto.Marshal(&cb.Consortium{Name: consortiumName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelGroup.Values[config.ConsortiumKey] = &cb.ConfigValue{Value: consortiumBytes}
+
+	envelope := newTestConfigEnvelope(t, chainID, channelGroup)
+	genesis := newTestBlock(t, 0, nil, envelope)
+	configBlock := newTestBlock(t, 1, genesis, envelope)
+
+	return []*cb.Block{genesis, configBlock}
+}
+
+func TestVerifyBlockChain(t *testing.T) {
+	t.Run("GenesisBlockMustBeNumberZero", func(t *testing.T) {
+		genesis := newTestBlock(t, 1, nil, &cb.Envelope{})
+		if err := verifyBlockChain([]*cb.Block{genesis}); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("NonContiguousBlockNumberRejected", func(t *testing.T) {
+		genesis := newTestBlock(t, 0, nil, &cb.Envelope{})
+		skipped := newTestBlock(t, 2, genesis, &cb.Envelope{})
+		if err := verifyBlockChain([]*cb.Block{genesis, skipped}); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("MismatchedPreviousHashRejected", func(t *testing.T) {
+		genesis := newTestBlock(t, 0, nil, &cb.Envelope{})
+		next := newTestBlock(t, 1, genesis, &cb.Envelope{})
+		next.Header.PreviousHash = []byte("not the genesis block's hash")
+		if err := verifyBlockChain([]*cb.Block{genesis, next}); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("ProperlyChainedRangeAccepted", func(t *testing.T) {
+		blocks := newTestChainBlocks(t, "mychannel", "SampleConsortium")
+		if err := verifyBlockChain(blocks); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+}
+
+func TestVerifyConfigBlockHash(t *testing.T) {
+	blocks := newTestChainBlocks(t, "mychannel", "SampleConsortium")
+	configBlock := blocks[len(blocks)-1]
+	actualHash := utils.GetBlockHeaderHash(configBlock.Header)
+
+	t.Run("MatchingHashAccepted", func(t *testing.T) {
+		if err := verifyConfigBlockHash(configBlock, actualHash); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+
+	t.Run("MismatchedHashRejected", func(t *testing.T) {
+		if err := verifyConfigBlockHash(configBlock, []byte("a different hash entirely")); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+}
+
+// TestVerifyConsortiumMembership covers the accept/reject paths
+// adoptDiscoveredChain relies on to keep a pulled chain from joining a
+// consortium the local system channel does not actually have.
+func TestVerifyConsortiumMembership(t *testing.T) {
+	systemChannelGroup := cb.NewConfigGroup()
+	consortiumsGroup := cb.NewConfigGroup()
+	consortiumsGroup.Groups["SampleConsortium"] = cb.NewConfigGroup()
+	systemChannelGroup.Groups[config.ConsortiumsGroupKey] = consortiumsGroup
+
+	t.Run("KnownConsortiumAccepted", func(t *testing.T) {
+		if err := verifyConsortiumMembership(systemChannelGroup, "SampleConsortium"); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+
+	t.Run("UnknownConsortiumRejected", func(t *testing.T) {
+		if err := verifyConsortiumMembership(systemChannelGroup, "NotAConsortium"); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("SystemChannelWithoutConsortiumsSupportRejected", func(t *testing.T) {
+		if err := verifyConsortiumMembership(cb.NewConfigGroup(), "SampleConsortium"); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+}
+
+// TestPullAndAdoptRejectsConfigBlockHashMismatch exercises pullAndAdopt end
+// to end with a real, properly hash-chained genesis+config block pair: when
+// the advertised hash does not match the pulled config block, the chain
+// must never reach adoptDiscoveredChain (and so never reach ml.systemChannel,
+// which newTestMultiLedger leaves nil) and must not become reachable via
+// GetChain.
+//
+// The matching-hash path that goes on to call adoptDiscoveredChain is not
+// exercised here: this snapshot has no chainSupport/configtxapi
+// implementation for ml.systemChannel to be backed by (see
+// verifyConsortiumMembership and its tests for the consortium-membership
+// check exercised in isolation instead), so there is no way to construct a
+// multiLedger here that survives past the hash check without a real one.
+func TestPullAndAdoptRejectsConfigBlockHashMismatch(t *testing.T) {
+	ml := newTestMultiLedger()
+	blocks := newTestChainBlocks(t, "mychannel", "SampleConsortium")
+
+	transport := &fakeTransport{blocks: blocks}
+	gcd := newGossipChannelDiscovery(ml, transport, 0, 0)
+
+	gcd.pullAndAdopt("peer1", "mychannel", []byte("a hash peer1 never actually advertised"))
+
+	if _, ok := ml.GetChain("mychannel"); ok {
+		t.Fatalf("expected a config block hash mismatch to keep the chain from being adopted")
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// grpcTransport is the initial DiscoveryTransport implementation: it calls
+// the ChannelDiscovery gRPC service exposed by peer orderers.
+type grpcTransport struct {
+	mutex    sync.Mutex
+	peers    []string
+	conns    map[string]*grpc.ClientConn
+	dialOpts []grpc.DialOption
+}
+
+// NewGRPCTransport returns a DiscoveryTransport which contacts peers over
+// gRPC. peers is the initial set of orderer addresses to pull from; it may
+// be updated concurrently via SetPeers as the network topology changes.
+func NewGRPCTransport(peers []string, dialOpts ...grpc.DialOption) DiscoveryTransport {
+	return &grpcTransport{
+		peers:    append([]string(nil), peers...),
+		conns:    make(map[string]*grpc.ClientConn),
+		dialOpts: dialOpts,
+	}
+}
+
+// SetPeers replaces the set of peer addresses future pull rounds select
+// from.
+func (gt *grpcTransport) SetPeers(peers []string) {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	gt.peers = append([]string(nil), peers...)
+}
+
+func (gt *grpcTransport) Peers() []string {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	return append([]string(nil), gt.peers...)
+}
+
+func (gt *grpcTransport) client(peer string) (ab.ChannelDiscoveryClient, error) {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+
+	conn, ok := gt.conns[peer]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(peer, gt.dialOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed dialing peer %s: %s", peer, err)
+		}
+		gt.conns[peer] = conn
+	}
+
+	return ab.NewChannelDiscoveryClient(conn), nil
+}
+
+func (gt *grpcTransport) KnownChains(peer string) (map[string][]byte, error) {
+	client, err := gt.client(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.KnownChains(context.Background(), &ab.KnownChainsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string][]byte, len(resp.Chains))
+	for _, summary := range resp.Chains {
+		known[summary.ChainId] = summary.ConfigBlockHash
+	}
+	return known, nil
+}
+
+func (gt *grpcTransport) PullChain(peer string, chainID string) ([]*cb.Block, error) {
+	client, err := gt.client(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.PullChain(context.Background(), &ab.PullChainRequest{ChainId: chainID})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []*cb.Block
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("peer %s returned no blocks for chain %s", peer, chainID)
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/config"
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+// ChannelCreationHook is invoked by NewChannelConfig against the draft
+// ChannelGroup templated for a new channel, before that group is wrapped in
+// a signed config envelope. Hooks may inspect or mutate the ChannelGroup in
+// place (for example to inject default orderer addresses, enforce org
+// naming conventions, or stamp custom metadata values); signatures carries
+// the signatures attached to the original channel creation request, for
+// hooks that need to inspect who signed it. Returning an error aborts
+// channel creation; the error is returned from NewChannelConfig and
+// propagates back to the broadcast client.
+type ChannelCreationHook interface {
+	HandleChannelCreation(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error
+}
+
+// ChannelCreationHookFunc adapts an ordinary function to a
+// ChannelCreationHook, following the same pattern as http.HandlerFunc.
+type ChannelCreationHookFunc func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error
+
+// HandleChannelCreation calls f(configUpdate, consortium, channelGroup, signatures).
+func (f ChannelCreationHookFunc) HandleChannelCreation(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+	return f(configUpdate, consortium, channelGroup, signatures)
+}
+
+// ManagerOption configures a Manager at construction time.
+type ManagerOption func(*multiLedger)
+
+// WithChannelCreationHooks registers additional hooks to run, in the given
+// order and after the built-in channel-creation-policy and
+// signer-verification hooks, against the draft ChannelGroup of every
+// channel creation request.
+func WithChannelCreationHooks(hooks ...ChannelCreationHook) ManagerOption {
+	return func(ml *multiLedger) {
+		ml.channelCreationHooks = append(ml.channelCreationHooks, hooks...)
+	}
+}
+
+// runChannelCreationHooks invokes the registered hooks in registration
+// order, stopping and returning the first error encountered.
+func (ml *multiLedger) runChannelCreationHooks(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+	for _, hook := range ml.channelCreationHooks {
+		if err := hook.HandleChannelCreation(configUpdate, consortium, channelGroup, signatures); err != nil {
+			return fmt.Errorf("channel creation hook rejected channel %s: %s", configUpdate.ChannelId, err)
+		}
+	}
+	return nil
+}
+
+// defaultChannelCreationPolicyHook is registered on every Manager ahead of
+// any caller-supplied hooks. NewChannelConfig stamps the consortium's
+// channel creation policy onto the draft application group before hooks
+// run; this hook decodes that policy and rejects the request if it is
+// missing or is not a well-formed policy of a type the orderer can later
+// evaluate. Without this check a malformed policy copied out of the
+// consortium config would sail through channel creation and only surface
+// as an inscrutable failure the first time something tries to evaluate it
+// against a signed config update.
+var defaultChannelCreationPolicyHook = ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+	applicationGroup, ok := channelGroup.Groups[config.ApplicationGroupKey]
+	if !ok {
+		return fmt.Errorf("draft channel group has no application group")
+	}
+
+	creationPolicy, ok := applicationGroup.Policies[config.ChannelCreationPolicyKey]
+	if !ok {
+		return fmt.Errorf("application group is missing a channel creation policy")
+	}
+
+	if creationPolicy.Policy == nil {
+		return fmt.Errorf("consortium %s channel creation policy is empty", consortium.Name)
+	}
+
+	switch creationPolicy.Policy.Type {
+	case int32(cb.Policy_SIGNATURE):
+		sigPolicy := &cb.SignaturePolicyEnvelope{}
+		if err := proto.Unmarshal(creationPolicy.Policy.Value, sigPolicy); err != nil {
+			return fmt.Errorf("consortium %s channel creation policy is not a valid signature policy: %s", consortium.Name, err)
+		}
+		if sigPolicy.Rule == nil {
+			return fmt.Errorf("consortium %s channel creation policy has no signature rule", consortium.Name)
+		}
+	case int32(cb.Policy_IMPLICIT_META):
+		implicitPolicy := &cb.ImplicitMetaPolicy{}
+		if err := proto.Unmarshal(creationPolicy.Policy.Value, implicitPolicy); err != nil {
+			return fmt.Errorf("consortium %s channel creation policy is not a valid implicit meta policy: %s", consortium.Name, err)
+		}
+	default:
+		return fmt.Errorf("consortium %s channel creation policy has unsupported type %d", consortium.Name, creationPolicy.Policy.Type)
+	}
+
+	return nil
+})
+
+// defaultSignerVerificationHook is registered on every Manager immediately
+// after defaultChannelCreationPolicyHook. It confirms that the channel
+// creation request actually carries at least one structurally well-formed
+// signature -- a non-empty Signature and a SignatureHeader naming a
+// Creator -- before the orderer commits to the request. It deliberately
+// does not evaluate those signatures against the channel creation policy:
+// that requires an identity-aware policy evaluator (cauthdsl bound to an
+// MSP deserializer), which this package does not depend on and which the
+// configtx manager already applies once the signed config envelope this
+// method returns is itself submitted as a config update.
+var defaultSignerVerificationHook = ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+	if len(signatures) == 0 {
+		return fmt.Errorf("channel creation request for %s carries no signatures", configUpdate.ChannelId)
+	}
+
+	for i, sig := range signatures {
+		if len(sig.Signature) == 0 {
+			return fmt.Errorf("signature %d on channel creation request for %s is empty", i, configUpdate.ChannelId)
+		}
+
+		header := &cb.SignatureHeader{}
+		if err := proto.Unmarshal(sig.SignatureHeader, header); err != nil {
+			return fmt.Errorf("signature %d on channel creation request for %s has an unparsable signature header: %s", i, configUpdate.ChannelId, err)
+		}
+		if len(header.Creator) == 0 {
+			return fmt.Errorf("signature %d on channel creation request for %s does not name a creator", i, configUpdate.ChannelId)
+		}
+	}
+
+	return nil
+})
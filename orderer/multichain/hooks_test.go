@@ -0,0 +1,304 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/config"
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+func newDraftChannelGroup(creationPolicy *cb.Policy) *cb.ConfigGroup {
+	applicationGroup := cb.NewConfigGroup()
+	if creationPolicy != nil {
+		applicationGroup.Policies[config.ChannelCreationPolicyKey] = &cb.ConfigPolicy{Policy: creationPolicy}
+	}
+
+	channelGroup := cb.NewConfigGroup()
+	channelGroup.Groups[config.ApplicationGroupKey] = applicationGroup
+	return channelGroup
+}
+
+func validSignaturePolicy() *cb.Policy {
+	sigPolicyBytes, err := proto.Marshal(&cb.SignaturePolicyEnvelope{
+		Rule: &cb.SignaturePolicy{},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &cb.Policy{Type: int32(cb.Policy_SIGNATURE), Value: sigPolicyBytes}
+}
+
+func oneValidSignature() []*cb.ConfigSignature {
+	header, err := proto.Marshal(&cb.SignatureHeader{Creator: []byte("Org1Admin")})
+	if err != nil {
+		panic(err)
+	}
+	return []*cb.ConfigSignature{
+		{SignatureHeader: header, Signature: []byte("signature-bytes")},
+	}
+}
+
+func TestRunChannelCreationHooksOrdering(t *testing.T) {
+	var calls []string
+
+	builtin := ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+		calls = append(calls, "builtin")
+		return nil
+	})
+	first := ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	second := ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+		calls = append(calls, "second")
+		return nil
+	})
+
+	ml := &multiLedger{channelCreationHooks: []ChannelCreationHook{builtin, first, second}}
+
+	err := ml.runChannelCreationHooks(&cb.ConfigUpdate{ChannelId: "mychannel"}, &cb.Consortium{Name: "SampleConsortium"}, newDraftChannelGroup(validSignaturePolicy()), oneValidSignature())
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	expected := []string{"builtin", "first", "second"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+	for i, name := range expected {
+		if calls[i] != name {
+			t.Fatalf("expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestRunChannelCreationHooksErrorPropagation(t *testing.T) {
+	var calls []string
+
+	first := ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+		calls = append(calls, "first")
+		return fmt.Errorf("rejected by policy")
+	})
+	second := ChannelCreationHookFunc(func(configUpdate *cb.ConfigUpdate, consortium *cb.Consortium, channelGroup *cb.ConfigGroup, signatures []*cb.ConfigSignature) error {
+		calls = append(calls, "second")
+		return nil
+	})
+
+	ml := &multiLedger{channelCreationHooks: []ChannelCreationHook{first, second}}
+
+	err := ml.runChannelCreationHooks(&cb.ConfigUpdate{ChannelId: "mychannel"}, &cb.Consortium{Name: "SampleConsortium"}, newDraftChannelGroup(validSignaturePolicy()), oneValidSignature())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(calls) != 1 || calls[0] != "first" {
+		t.Fatalf("expected only the failing hook to run, got %v", calls)
+	}
+}
+
+func TestDefaultChannelCreationPolicyHook(t *testing.T) {
+	configUpdate := &cb.ConfigUpdate{ChannelId: "mychannel"}
+	consortium := &cb.Consortium{Name: "SampleConsortium"}
+	signatures := oneValidSignature()
+
+	t.Run("MissingApplicationGroup", func(t *testing.T) {
+		channelGroup := cb.NewConfigGroup()
+		if err := defaultChannelCreationPolicyHook.HandleChannelCreation(configUpdate, consortium, channelGroup, signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("MissingCreationPolicy", func(t *testing.T) {
+		if err := defaultChannelCreationPolicyHook.HandleChannelCreation(configUpdate, consortium, newDraftChannelGroup(nil), signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("MalformedSignaturePolicy", func(t *testing.T) {
+		malformed := &cb.Policy{Type: int32(cb.Policy_SIGNATURE), Value: []byte("not a signature policy")}
+		if err := defaultChannelCreationPolicyHook.HandleChannelCreation(configUpdate, consortium, newDraftChannelGroup(malformed), signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("UnsupportedPolicyType", func(t *testing.T) {
+		unsupported := &cb.Policy{Type: int32(cb.Policy_UNKNOWN)}
+		if err := defaultChannelCreationPolicyHook.HandleChannelCreation(configUpdate, consortium, newDraftChannelGroup(unsupported), signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("ValidSignaturePolicy", func(t *testing.T) {
+		if err := defaultChannelCreationPolicyHook.HandleChannelCreation(configUpdate, consortium, newDraftChannelGroup(validSignaturePolicy()), signatures); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+}
+
+func TestDefaultSignerVerificationHook(t *testing.T) {
+	configUpdate := &cb.ConfigUpdate{ChannelId: "mychannel"}
+	consortium := &cb.Consortium{Name: "SampleConsortium"}
+	channelGroup := newDraftChannelGroup(validSignaturePolicy())
+
+	t.Run("NoSignatures", func(t *testing.T) {
+		err := defaultSignerVerificationHook.HandleChannelCreation(configUpdate, consortium, channelGroup, nil)
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("EmptySignatureBytes", func(t *testing.T) {
+		header, err := proto.Marshal(&cb.SignatureHeader{Creator: []byte("Org1Admin")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures := []*cb.ConfigSignature{{SignatureHeader: header}}
+		if err := defaultSignerVerificationHook.HandleChannelCreation(configUpdate, consortium, channelGroup, signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("UnparsableSignatureHeader", func(t *testing.T) {
+		signatures := []*cb.ConfigSignature{{SignatureHeader: []byte("not a signature header"), Signature: []byte("sig")}}
+		if err := defaultSignerVerificationHook.HandleChannelCreation(configUpdate, consortium, channelGroup, signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("NoCreator", func(t *testing.T) {
+		header, err := proto.Marshal(&cb.SignatureHeader{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures := []*cb.ConfigSignature{{SignatureHeader: header, Signature: []byte("sig")}}
+		if err := defaultSignerVerificationHook.HandleChannelCreation(configUpdate, consortium, channelGroup, signatures); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("ValidSignature", func(t *testing.T) {
+		if err := defaultSignerVerificationHook.HandleChannelCreation(configUpdate, consortium, channelGroup, oneValidSignature()); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+}
+
+// TestHooksRunAfterConsortiumMembershipValidation exercises the actual
+// sequence NewChannelConfig follows: applyApplicationGroupMembership
+// resolves and validates consortium membership first, and only a request
+// that survives that check ever reaches runChannelCreationHooks. This
+// covers the hooks' interaction with the existing consortium membership
+// checks, rather than asserting on the hooks in isolation.
+func TestHooksRunAfterConsortiumMembershipValidation(t *testing.T) {
+	systemChannelGroup := cb.NewConfigGroup()
+	consortiumsGroup := cb.NewConfigGroup()
+	sampleConsortiumGroup := cb.NewConfigGroup()
+	sampleConsortiumGroup.Groups["Org1"] = cb.NewConfigGroup()
+	consortiumsGroup.Groups["SampleConsortium"] = sampleConsortiumGroup
+	systemChannelGroup.Groups[config.ConsortiumsGroupKey] = consortiumsGroup
+
+	configUpdate := &cb.ConfigUpdate{ChannelId: "mychannel"}
+	consortium := &cb.Consortium{Name: "SampleConsortium"}
+	signatures := oneValidSignature()
+
+	ml := &multiLedger{channelCreationHooks: []ChannelCreationHook{defaultChannelCreationPolicyHook, defaultSignerVerificationHook}}
+
+	t.Run("UnknownMemberRejectedBeforeHooksRun", func(t *testing.T) {
+		var hookRan bool
+		ml := &multiLedger{channelCreationHooks: append([]ChannelCreationHook{ChannelCreationHookFunc(func(*cb.ConfigUpdate, *cb.Consortium, *cb.ConfigGroup, []*cb.ConfigSignature) error {
+			hookRan = true
+			return nil
+		})}, ml.channelCreationHooks...)}
+
+		applicationGroup := cb.NewConfigGroup()
+		requestedGroups := map[string]*cb.ConfigGroup{"NotAMember": cb.NewConfigGroup()}
+
+		err := applyApplicationGroupMembership(systemChannelGroup, consortium.Name, applicationGroup, requestedGroups)
+		if err == nil {
+			t.Fatalf("expected membership validation to reject an unknown org")
+		}
+		if hookRan {
+			t.Fatalf("hooks must not run once membership validation has already failed")
+		}
+	})
+
+	t.Run("ValidMemberReachesHooks", func(t *testing.T) {
+		applicationGroup := cb.NewConfigGroup()
+		applicationGroup.Policies[config.ChannelCreationPolicyKey] = &cb.ConfigPolicy{Policy: validSignaturePolicy()}
+		requestedGroups := map[string]*cb.ConfigGroup{"Org1": cb.NewConfigGroup()}
+
+		if err := applyApplicationGroupMembership(systemChannelGroup, consortium.Name, applicationGroup, requestedGroups); err != nil {
+			t.Fatalf("expected membership validation to pass, got %s", err)
+		}
+		if _, ok := applicationGroup.Groups["Org1"]; !ok {
+			t.Fatalf("expected Org1 to be copied into the application group")
+		}
+
+		channelGroup := cb.NewConfigGroup()
+		channelGroup.Groups[config.ApplicationGroupKey] = applicationGroup
+
+		if err := ml.runChannelCreationHooks(configUpdate, consortium, channelGroup, signatures); err != nil {
+			t.Fatalf("expected hooks to accept a valid, membership-checked request, got %s", err)
+		}
+	})
+}
+
+func TestApplyApplicationGroupMembership(t *testing.T) {
+	systemChannelGroup := cb.NewConfigGroup()
+	consortiumsGroup := cb.NewConfigGroup()
+	sampleConsortiumGroup := cb.NewConfigGroup()
+	sampleConsortiumGroup.Groups["Org1"] = cb.NewConfigGroup()
+	consortiumsGroup.Groups["SampleConsortium"] = sampleConsortiumGroup
+	consortiumsGroup.Groups["EmptyConsortium"] = cb.NewConfigGroup()
+	systemChannelGroup.Groups[config.ConsortiumsGroupKey] = consortiumsGroup
+
+	t.Run("UnknownOrgRejected", func(t *testing.T) {
+		applicationGroup := cb.NewConfigGroup()
+		requestedGroups := map[string]*cb.ConfigGroup{"NotAMember": cb.NewConfigGroup()}
+		if err := applyApplicationGroupMembership(systemChannelGroup, "SampleConsortium", applicationGroup, requestedGroups); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("EmptyRequestRejectedWhenConsortiumHasMembers", func(t *testing.T) {
+		applicationGroup := cb.NewConfigGroup()
+		if err := applyApplicationGroupMembership(systemChannelGroup, "SampleConsortium", applicationGroup, nil); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("EmptyRequestAllowedWhenConsortiumHasNoMembers", func(t *testing.T) {
+		applicationGroup := cb.NewConfigGroup()
+		if err := applyApplicationGroupMembership(systemChannelGroup, "EmptyConsortium", applicationGroup, nil); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	})
+
+	t.Run("KnownOrgCopiedIntoApplicationGroup", func(t *testing.T) {
+		applicationGroup := cb.NewConfigGroup()
+		requestedGroups := map[string]*cb.ConfigGroup{"Org1": cb.NewConfigGroup()}
+		if err := applyApplicationGroupMembership(systemChannelGroup, "SampleConsortium", applicationGroup, requestedGroups); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if _, ok := applicationGroup.Groups["Org1"]; !ok {
+			t.Fatalf("expected Org1 to be copied into the application group")
+		}
+	})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/config"
+	cb "github.com/hyperledger/fabric/protos/common"
+)
+
+// listenerQueueDepth bounds how many undelivered events a single listener
+// may accumulate before newer events are dropped for it.
+const listenerQueueDepth = 64
+
+// ChannelLifecycleEventType identifies the kind of change a
+// ChannelLifecycleEvent describes.
+type ChannelLifecycleEventType int
+
+const (
+	// ChannelCreated fires once newChain has materialized a chainSupport
+	// for a channel, before the chain has been started.
+	ChannelCreated ChannelLifecycleEventType = iota
+
+	// ChannelStarted fires once a chain's start() has returned.
+	ChannelStarted
+)
+
+// ChannelLifecycleEvent describes a channel topology change: a channel
+// being created or started (and, in the future, halted).
+type ChannelLifecycleEvent struct {
+	Type           ChannelLifecycleEventType
+	ChainID        string
+	ConsortiumName string
+	ConsensusType  string
+	ConfigSequence uint64
+}
+
+// ChannelLifecycleListener is notified of ChannelLifecycleEvents by a
+// Manager it has Subscribed to. HandleChannelLifecycleEvent is called from
+// a dedicated per-listener goroutine, never concurrently with itself, but
+// concurrently with other listeners' callbacks.
+type ChannelLifecycleListener interface {
+	HandleChannelLifecycleEvent(event ChannelLifecycleEvent)
+}
+
+// lifecycleDispatcher fans ChannelLifecycleEvents out to subscribed
+// listeners. Each listener gets its own bounded queue and goroutine, so a
+// slow or stuck listener can only ever stall itself, never newChain's map
+// swap or the delivery of events to other listeners.
+type lifecycleDispatcher struct {
+	mutex     sync.Mutex
+	listeners map[ChannelLifecycleListener]chan ChannelLifecycleEvent
+}
+
+func newLifecycleDispatcher() *lifecycleDispatcher {
+	return &lifecycleDispatcher{
+		listeners: make(map[ChannelLifecycleListener]chan ChannelLifecycleEvent),
+	}
+}
+
+func (ld *lifecycleDispatcher) subscribe(listener ChannelLifecycleListener) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+
+	if _, ok := ld.listeners[listener]; ok {
+		return
+	}
+
+	queue := make(chan ChannelLifecycleEvent, listenerQueueDepth)
+	ld.listeners[listener] = queue
+
+	go func() {
+		for event := range queue {
+			listener.HandleChannelLifecycleEvent(event)
+		}
+	}()
+}
+
+func (ld *lifecycleDispatcher) unsubscribe(listener ChannelLifecycleListener) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+
+	queue, ok := ld.listeners[listener]
+	if !ok {
+		return
+	}
+	delete(ld.listeners, listener)
+	close(queue)
+}
+
+// dispatch delivers event to every subscribed listener's queue without
+// blocking; a listener whose queue is already full has the event dropped
+// for it, with a log warning, rather than stalling the caller.
+func (ld *lifecycleDispatcher) dispatch(event ChannelLifecycleEvent) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+
+	for _, queue := range ld.listeners {
+		select {
+		case queue <- event:
+		default:
+			logger.Warningf("channel lifecycle listener queue full, dropping event %v for chain %s", event.Type, event.ChainID)
+		}
+	}
+}
+
+// Subscribe registers listener to receive future channel lifecycle events.
+// Subscribing the same listener more than once is a no-op.
+func (ml *multiLedger) Subscribe(listener ChannelLifecycleListener) {
+	ml.lifecycle.subscribe(listener)
+}
+
+// Unsubscribe removes a previously registered listener. It is a no-op if
+// listener was never subscribed.
+func (ml *multiLedger) Unsubscribe(listener ChannelLifecycleListener) {
+	ml.lifecycle.unsubscribe(listener)
+}
+
+// dispatchChannelEvent builds and fans out a ChannelLifecycleEvent for cs.
+func (ml *multiLedger) dispatchChannelEvent(eventType ChannelLifecycleEventType, chainID string, cs *chainSupport) {
+	ml.lifecycle.dispatch(ChannelLifecycleEvent{
+		Type:           eventType,
+		ChainID:        chainID,
+		ConsortiumName: chainConsortiumName(cs),
+		ConsensusType:  cs.SharedConfig().ConsensusType(),
+		ConfigSequence: cs.Sequence(),
+	})
+}
+
+// chainConsortiumName best-effort extracts the consortium name a channel
+// was created against, returning "" if the channel's config does not carry
+// one (as is the case for the system channel itself).
+func chainConsortiumName(cs *chainSupport) string {
+	consortiumConfigValue, ok := cs.ConfigEnvelope().Config.ChannelGroup.Values[config.ConsortiumKey]
+	if !ok {
+		return ""
+	}
+
+	consortium := &cb.Consortium{}
+	if err := proto.Unmarshal(consortiumConfigValue.Value, consortium); err != nil {
+		return ""
+	}
+	return consortium.Name
+}
@@ -0,0 +1,188 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multichain
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingListener records every event it is handed, in delivery order.
+// HandleChannelLifecycleEvent is only ever called from the listener's own
+// dedicated goroutine, so no locking is needed around events itself, but
+// tests read events from a different goroutine and so must still guard it.
+type recordingListener struct {
+	mutex  sync.Mutex
+	events []ChannelLifecycleEvent
+}
+
+func (rl *recordingListener) HandleChannelLifecycleEvent(event ChannelLifecycleEvent) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.events = append(rl.events, event)
+}
+
+func (rl *recordingListener) count() int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return len(rl.events)
+}
+
+// blockingListener blocks every call until release is closed, simulating a
+// stuck or slow subscriber.
+type blockingListener struct {
+	release chan struct{}
+	calls   int32
+	mutex   sync.Mutex
+}
+
+func (bl *blockingListener) HandleChannelLifecycleEvent(event ChannelLifecycleEvent) {
+	bl.mutex.Lock()
+	bl.calls++
+	bl.mutex.Unlock()
+	<-bl.release
+}
+
+func (bl *blockingListener) callCount() int32 {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	return bl.calls
+}
+
+func waitForCount(t *testing.T, timeout time.Duration, count func() int, want int) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for count() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d events, got %d within %s", want, count(), timeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLifecycleDispatchDeliversToAllListeners(t *testing.T) {
+	ld := newLifecycleDispatcher()
+
+	first := &recordingListener{}
+	second := &recordingListener{}
+	ld.subscribe(first)
+	ld.subscribe(second)
+
+	ld.dispatch(ChannelLifecycleEvent{Type: ChannelCreated, ChainID: "mychannel"})
+
+	waitForCount(t, time.Second, first.count, 1)
+	waitForCount(t, time.Second, second.count, 1)
+}
+
+func TestLifecycleUnsubscribeStopsDelivery(t *testing.T) {
+	ld := newLifecycleDispatcher()
+
+	listener := &recordingListener{}
+	ld.subscribe(listener)
+	ld.dispatch(ChannelLifecycleEvent{Type: ChannelCreated, ChainID: "mychannel"})
+	waitForCount(t, time.Second, listener.count, 1)
+
+	ld.unsubscribe(listener)
+	ld.dispatch(ChannelLifecycleEvent{Type: ChannelStarted, ChainID: "mychannel"})
+
+	// There is nothing to block on for an absence, so just give the
+	// dispatcher a moment to have (wrongly) delivered the event if it was
+	// going to.
+	time.Sleep(10 * time.Millisecond)
+	if count := listener.count(); count != 1 {
+		t.Fatalf("expected no events after unsubscribe, got %d", count-1)
+	}
+}
+
+func TestLifecycleSubscribeSameListenerTwiceIsNoop(t *testing.T) {
+	ld := newLifecycleDispatcher()
+
+	listener := &recordingListener{}
+	ld.subscribe(listener)
+	ld.subscribe(listener)
+
+	ld.dispatch(ChannelLifecycleEvent{Type: ChannelCreated, ChainID: "mychannel"})
+
+	waitForCount(t, time.Second, listener.count, 1)
+	time.Sleep(10 * time.Millisecond)
+	if count := listener.count(); count != 1 {
+		t.Fatalf("expected subscribing the same listener twice to register it once, got %d deliveries", count)
+	}
+}
+
+// TestLifecycleDispatchDropsOnFullQueueWithoutBlocking is the core claim of
+// the bounded per-listener queue: a stuck listener can fill its own queue,
+// but dispatch must keep returning immediately rather than stalling on it
+// (and, transitively, stalling newChain's map swap).
+func TestLifecycleDispatchDropsOnFullQueueWithoutBlocking(t *testing.T) {
+	ld := newLifecycleDispatcher()
+
+	stuck := &blockingListener{release: make(chan struct{})}
+	ld.subscribe(stuck)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The first dispatch is picked up by the listener goroutine and
+		// blocks it; the next listenerQueueDepth fill the queue; any
+		// beyond that must be dropped rather than block this goroutine.
+		for i := 0; i < listenerQueueDepth+10; i++ {
+			ld.dispatch(ChannelLifecycleEvent{Type: ChannelCreated, ChainID: "mychannel"})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("dispatch blocked with a stuck listener instead of dropping excess events")
+	}
+
+	close(stuck.release)
+}
+
+// TestLifecycleDispatchConcurrentWithSubscribe races Subscribe/Unsubscribe
+// against dispatch, the same contention newChain's event firing sees
+// against a concurrently (un)subscribing listener. Run with -race.
+func TestLifecycleDispatchConcurrentWithSubscribe(t *testing.T) {
+	ld := newLifecycleDispatcher()
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				ld.dispatch(ChannelLifecycleEvent{Type: ChannelCreated, ChainID: "mychannel"})
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		listener := &recordingListener{}
+		ld.subscribe(listener)
+		ld.unsubscribe(listener)
+	}
+
+	close(stopCh)
+	wg.Wait()
+}
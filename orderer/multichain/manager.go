@@ -18,6 +18,8 @@ package multichain
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hyperledger/fabric/common/config"
 	"github.com/hyperledger/fabric/common/configtx"
@@ -49,6 +51,13 @@ type Manager interface {
 	// NewChannelConfig returns a bare bones configuration ready for channel
 	// creation request to be applied on top of it
 	NewChannelConfig(envConfigUpdate *cb.Envelope) (configtxapi.Manager, error)
+
+	// Subscribe registers listener to receive future channel lifecycle
+	// events, such as channel creation and chain start.
+	Subscribe(listener ChannelLifecycleListener)
+
+	// Unsubscribe removes a previously registered listener.
+	Unsubscribe(listener ChannelLifecycleListener)
 }
 
 type configResources struct {
@@ -65,12 +74,22 @@ type ledgerResources struct {
 }
 
 type multiLedger struct {
-	chains          map[string]*chainSupport
-	consenters      map[string]Consenter
-	ledgerFactory   ledger.Factory
-	signer          crypto.LocalSigner
-	systemChannelID string
-	systemChannel   *chainSupport
+	// chains holds an immutable map[string]*chainSupport. Readers (GetChain,
+	// channelsCount) load it without synchronization; writers (addChain)
+	// serialize on chainsMutex and swap in a freshly copied map, so a reader
+	// never observes a partially built one.
+	chains      atomic.Value
+	chainsMutex sync.Mutex
+
+	consenters           map[string]Consenter
+	ledgerFactory        ledger.Factory
+	signer               crypto.LocalSigner
+	systemChannelID      string
+	systemChannel        *chainSupport
+	channelCreationHooks []ChannelCreationHook
+	channelDiscovery     ChannelDiscovery
+	discoveryStopCh      chan struct{}
+	lifecycle            *lifecycleDispatcher
 }
 
 func getConfigTx(reader ledger.Reader) *cb.Envelope {
@@ -88,14 +107,22 @@ func getConfigTx(reader ledger.Reader) *cb.Envelope {
 }
 
 // NewManagerImpl produces an instance of a Manager
-func NewManagerImpl(ledgerFactory ledger.Factory, consenters map[string]Consenter, signer crypto.LocalSigner) Manager {
+func NewManagerImpl(ledgerFactory ledger.Factory, consenters map[string]Consenter, signer crypto.LocalSigner, options ...ManagerOption) Manager {
 	ml := &multiLedger{
-		chains:        make(map[string]*chainSupport),
-		ledgerFactory: ledgerFactory,
-		consenters:    consenters,
-		signer:        signer,
+		ledgerFactory:        ledgerFactory,
+		consenters:           consenters,
+		signer:               signer,
+		channelCreationHooks: []ChannelCreationHook{defaultChannelCreationPolicyHook, defaultSignerVerificationHook},
+		lifecycle:            newLifecycleDispatcher(),
+	}
+	ml.chains.Store(make(map[string]*chainSupport))
+
+	for _, option := range options {
+		option(ml)
 	}
 
+	bootstrapChains := make(map[string]*chainSupport)
+
 	existingChains := ledgerFactory.ChainIDs()
 	for _, chainID := range existingChains {
 		rl, err := ledgerFactory.GetOrCreate(chainID)
@@ -118,7 +145,7 @@ func NewManagerImpl(ledgerFactory ledger.Factory, consenters map[string]Consente
 				consenters,
 				signer)
 			logger.Infof("Starting with system channel %s and orderer type %s", chainID, chain.SharedConfig().ConsensusType())
-			ml.chains[string(chainID)] = chain
+			bootstrapChains[string(chainID)] = chain
 			ml.systemChannelID = chainID
 			ml.systemChannel = chain
 			// We delay starting this chain, as it might try to copy and replace the chains map via newChain before the map is fully built
@@ -129,16 +156,27 @@ func NewManagerImpl(ledgerFactory ledger.Factory, consenters map[string]Consente
 				ledgerResources,
 				consenters,
 				signer)
-			ml.chains[string(chainID)] = chain
+			bootstrapChains[string(chainID)] = chain
 			chain.start()
 		}
 
 	}
 
+	// Publish the bootstrap set in a single atomic swap, before any of the
+	// deferred chain.start() calls above run, so GetChain never observes a
+	// partially built map even though those chains may themselves trigger
+	// concurrent channel creation.
+	ml.chains.Store(bootstrapChains)
+
 	if ml.systemChannelID == "" {
 		logger.Panicf("No system chain found")
 	}
 
+	if ml.channelDiscovery != nil {
+		ml.discoveryStopCh = make(chan struct{})
+		go ml.channelDiscovery.Run(ml.discoveryStopCh)
+	}
+
 	return ml
 }
 
@@ -148,7 +186,7 @@ func (ml *multiLedger) SystemChannelID() string {
 
 // GetChain retrieves the chain support for a chain (and whether it exists)
 func (ml *multiLedger) GetChain(chainID string) (ChainSupport, bool) {
-	cs, ok := ml.chains[chainID]
+	cs, ok := ml.chains.Load().(map[string]*chainSupport)[chainID]
 	return cs, ok
 }
 
@@ -176,25 +214,84 @@ func (ml *multiLedger) newChain(configtx *cb.Envelope) {
 	ledgerResources := ml.newLedgerResources(configtx)
 	ledgerResources.ledger.Append(ledger.CreateNextBlock(ledgerResources.ledger, []*cb.Envelope{configtx}))
 
-	// Copy the map to allow concurrent reads from broadcast/deliver while the new chainSupport is
-	newChains := make(map[string]*chainSupport)
-	for key, value := range ml.chains {
-		newChains[key] = value
-	}
-
 	cs := newChainSupport(createStandardFilters(ledgerResources), ledgerResources, ml.consenters, ml.signer)
 	chainID := ledgerResources.ChainID()
 
 	logger.Infof("Created and starting new chain %s", chainID)
+	ml.dispatchChannelEvent(ChannelCreated, chainID, cs)
 
-	newChains[string(chainID)] = cs
+	// Start the chain before publishing it via addChain: GetChain is read
+	// lock-free by broadcast/deliver goroutines with no synchronization
+	// against cs.start(), so a chain must be fully started before it can
+	// become reachable, or a racing reader could resolve a chainSupport
+	// whose consenter has not started yet.
 	cs.start()
+	ml.addChain(chainID, cs)
+	ml.dispatchChannelEvent(ChannelStarted, chainID, cs)
+}
 
-	ml.chains = newChains
+// addChain copies the current chains map, adds chainID/cs to the copy, and
+// atomically swaps it in, so that GetChain (read concurrently from
+// broadcast/deliver with no locking) never observes a partially built map.
+// Writers serialize on chainsMutex so two concurrent calls can't race to
+// publish a map that is missing one of their additions.
+func (ml *multiLedger) addChain(chainID string, cs *chainSupport) {
+	ml.chainsMutex.Lock()
+	defer ml.chainsMutex.Unlock()
+
+	current := ml.chains.Load().(map[string]*chainSupport)
+	newChains := make(map[string]*chainSupport, len(current)+1)
+	for key, value := range current {
+		newChains[key] = value
+	}
+	newChains[chainID] = cs
+	ml.chains.Store(newChains)
 }
 
 func (ml *multiLedger) channelsCount() int {
-	return len(ml.chains)
+	return len(ml.chains.Load().(map[string]*chainSupport))
+}
+
+// chainIDs returns the chainIDs of every chain this orderer currently has,
+// in no particular order.
+func (ml *multiLedger) chainIDs() []string {
+	current := ml.chains.Load().(map[string]*chainSupport)
+	chainIDs := make([]string, 0, len(current))
+	for chainID := range current {
+		chainIDs = append(chainIDs, chainID)
+	}
+	return chainIDs
+}
+
+// chainReader returns the ledger.Reader backing chainID's chain, and
+// whether chainID is known at all.
+func (ml *multiLedger) chainReader(chainID string) (ledger.Reader, bool) {
+	cs, ok := ml.chains.Load().(map[string]*chainSupport)[chainID]
+	if !ok {
+		return nil, false
+	}
+	return cs.ledger, true
+}
+
+// latestConfigBlock returns chainID's latest config block, the same block
+// getConfigTx extracts its envelope from at startup.
+func (ml *multiLedger) latestConfigBlock(chainID string) (*cb.Block, error) {
+	reader, ok := ml.chainReader(chainID)
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %s", chainID)
+	}
+
+	lastBlock := ledger.GetBlock(reader, reader.Height()-1)
+	index, err := utils.GetLastConfigIndexFromBlock(lastBlock)
+	if err != nil {
+		return nil, fmt.Errorf("chain did not have appropriately encoded last config in its latest block: %s", err)
+	}
+
+	configBlock := ledger.GetBlock(reader, index)
+	if configBlock == nil {
+		return nil, fmt.Errorf("config block does not exist")
+	}
+	return configBlock, nil
 }
 
 func (ml *multiLedger) NewChannelConfig(envConfigUpdate *cb.Envelope) (configtxapi.Manager, error) {
@@ -255,19 +352,8 @@ func (ml *multiLedger) NewChannelConfig(envConfigUpdate *cb.Envelope) (configtxa
 	// Get the current system channel config
 	systemChannelGroup := ml.systemChannel.ConfigEnvelope().Config.ChannelGroup
 
-	// If the consortium group has no members, allow the source request to have no members.  However,
-	// if the consortium group has any members, there must be at least one member in the source request
-	if len(systemChannelGroup.Groups[config.ConsortiumsGroupKey].Groups[consortium.Name].Groups) > 0 &&
-		len(configUpdate.WriteSet.Groups[config.ApplicationGroupKey].Groups) == 0 {
-		return nil, fmt.Errorf("Proposed configuration has no application group members, but consortium contains members")
-	}
-
-	for orgName := range configUpdate.WriteSet.Groups[config.ApplicationGroupKey].Groups {
-		consortiumGroup, ok := systemChannelGroup.Groups[config.ConsortiumsGroupKey].Groups[consortium.Name].Groups[orgName]
-		if !ok {
-			return nil, fmt.Errorf("Attempted to include a member which is not in the consortium")
-		}
-		applicationGroup.Groups[orgName] = consortiumGroup
+	if err := applyApplicationGroupMembership(systemChannelGroup, consortium.Name, applicationGroup, configUpdate.WriteSet.Groups[config.ApplicationGroupKey].Groups); err != nil {
+		return nil, err
 	}
 
 	channelGroup := cb.NewConfigGroup()
@@ -290,6 +376,10 @@ func (ml *multiLedger) NewChannelConfig(envConfigUpdate *cb.Envelope) (configtxa
 	channelGroup.Groups[config.ApplicationGroupKey] = applicationGroup
 	channelGroup.Values[config.ConsortiumKey] = config.TemplateConsortium(consortium.Name).Values[config.ConsortiumKey]
 
+	if err := ml.runChannelCreationHooks(configUpdate, consortium, channelGroup, configUpdateEnv.Signatures); err != nil {
+		return nil, err
+	}
+
 	templateConfig, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, configUpdate.ChannelId, ml.signer, &cb.ConfigEnvelope{
 		Config: &cb.Config{
 			ChannelGroup: channelGroup,
@@ -298,3 +388,27 @@ func (ml *multiLedger) NewChannelConfig(envConfigUpdate *cb.Envelope) (configtxa
 
 	return configtx.NewManagerImpl(templateConfig, configtx.NewInitializer(), nil)
 }
+
+// applyApplicationGroupMembership copies each org named in requestedGroups
+// from systemChannelGroup's consortium membership into applicationGroup,
+// rejecting any org that is not actually a member of consortiumName. If the
+// consortium has any members at all, requestedGroups must name at least
+// one of them: an empty application group is only allowed for consortiums
+// that themselves have no members.
+func applyApplicationGroupMembership(systemChannelGroup *cb.ConfigGroup, consortiumName string, applicationGroup *cb.ConfigGroup, requestedGroups map[string]*cb.ConfigGroup) error {
+	consortiumGroups := systemChannelGroup.Groups[config.ConsortiumsGroupKey].Groups[consortiumName].Groups
+
+	if len(consortiumGroups) > 0 && len(requestedGroups) == 0 {
+		return fmt.Errorf("Proposed configuration has no application group members, but consortium contains members")
+	}
+
+	for orgName := range requestedGroups {
+		consortiumGroup, ok := consortiumGroups[orgName]
+		if !ok {
+			return fmt.Errorf("Attempted to include a member which is not in the consortium")
+		}
+		applicationGroup.Groups[orgName] = consortiumGroup
+	}
+
+	return nil
+}